@@ -0,0 +1,179 @@
+// mautrix-signal - A Matrix-signal puppeting bridge.
+// Copyright (C) 2023 Sumner Evans
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package libsignalgo
+
+/*
+#cgo LDFLAGS: -lsignal_ffi -ldl -lm
+#include "./libsignal-ffi.h"
+*/
+import "C"
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"time"
+)
+
+// KyberPreKeyRecord is the post-quantum counterpart to SignedPreKeyRecord:
+// it wraps a Kyber prekey used in Signal's PQXDH handshake rather than the
+// classical X3DH signed prekey.
+type KyberPreKeyRecord struct {
+	nc  noCopy
+	ptr *C.SignalKyberPreKeyRecord
+}
+
+func wrapKyberPreKeyRecord(ptr *C.SignalKyberPreKeyRecord) *KyberPreKeyRecord {
+	kpkr := &KyberPreKeyRecord{ptr: ptr}
+	runtime.SetFinalizer(kpkr, (*KyberPreKeyRecord).Destroy)
+	return kpkr
+}
+
+func NewKyberPreKeyRecord(id uint32, timestamp time.Time, keyPair *KyberKeyPair, signature []byte) (*KyberPreKeyRecord, error) {
+	var kpkr *C.SignalKyberPreKeyRecord
+	signalFfiError := C.signal_kyber_pre_key_record_new(&kpkr, C.uint32_t(id), C.uint64_t(timestamp.UnixMilli()), keyPair.ptr, BytesToBuffer(signature))
+	runtime.KeepAlive(keyPair)
+	runtime.KeepAlive(signature)
+	if signalFfiError != nil {
+		return nil, wrapError(signalFfiError)
+	}
+	return wrapKyberPreKeyRecord(kpkr), nil
+}
+
+func DeserializeKyberPreKeyRecord(serialized []byte) (*KyberPreKeyRecord, error) {
+	var kpkr *C.SignalKyberPreKeyRecord
+	signalFfiError := C.signal_kyber_pre_key_record_deserialize(&kpkr, BytesToBuffer(serialized))
+	runtime.KeepAlive(serialized)
+	if signalFfiError != nil {
+		return nil, wrapError(signalFfiError)
+	}
+	return wrapKyberPreKeyRecord(kpkr), nil
+}
+
+func (kpkr *KyberPreKeyRecord) Clone() (*KyberPreKeyRecord, error) {
+	var cloned *C.SignalKyberPreKeyRecord
+	signalFfiError := C.signal_kyber_pre_key_record_clone(&cloned, kpkr.ptr)
+	runtime.KeepAlive(kpkr)
+	if signalFfiError != nil {
+		return nil, wrapError(signalFfiError)
+	}
+	return wrapKyberPreKeyRecord(cloned), nil
+}
+
+func (kpkr *KyberPreKeyRecord) Destroy() error {
+	kpkr.CancelFinalizer()
+	return wrapError(C.signal_kyber_pre_key_record_destroy(kpkr.ptr))
+}
+
+func (kpkr *KyberPreKeyRecord) CancelFinalizer() {
+	runtime.SetFinalizer(kpkr, nil)
+}
+
+func (kpkr *KyberPreKeyRecord) Serialize() ([]byte, error) {
+	var serialized C.SignalOwnedBuffer = C.SignalOwnedBuffer{}
+	signalFfiError := C.signal_kyber_pre_key_record_serialize(&serialized, kpkr.ptr)
+	runtime.KeepAlive(kpkr)
+	if signalFfiError != nil {
+		return nil, wrapError(signalFfiError)
+	}
+	return CopySignalOwnedBufferToBytes(serialized), nil
+}
+
+func (kpkr *KyberPreKeyRecord) GetSignature() ([]byte, error) {
+	var signature C.SignalOwnedBuffer = C.SignalOwnedBuffer{}
+	signalFfiError := C.signal_kyber_pre_key_record_get_signature(&signature, kpkr.ptr)
+	runtime.KeepAlive(kpkr)
+	if signalFfiError != nil {
+		return nil, wrapError(signalFfiError)
+	}
+	return CopySignalOwnedBufferToBytes(signature), nil
+}
+
+func (kpkr *KyberPreKeyRecord) GetID() (uint, error) {
+	var id C.uint32_t
+	signalFfiError := C.signal_kyber_pre_key_record_get_id(&id, kpkr.ptr)
+	runtime.KeepAlive(kpkr)
+	if signalFfiError != nil {
+		return 0, wrapError(signalFfiError)
+	}
+	return uint(id), nil
+}
+
+func (kpkr *KyberPreKeyRecord) GetTimestamp() (time.Time, error) {
+	var ts C.uint64_t
+	signalFfiError := C.signal_kyber_pre_key_record_get_timestamp(&ts, kpkr.ptr)
+	runtime.KeepAlive(kpkr)
+	if signalFfiError != nil {
+		return time.Time{}, wrapError(signalFfiError)
+	}
+	return time.UnixMilli(int64(ts)), nil
+}
+
+func (kpkr *KyberPreKeyRecord) GetPublicKey() (*KyberPublicKey, error) {
+	var pub *C.SignalKyberPublicKey
+	signalFfiError := C.signal_kyber_pre_key_record_get_public_key(&pub, kpkr.ptr)
+	runtime.KeepAlive(kpkr)
+	if signalFfiError != nil {
+		return nil, wrapError(signalFfiError)
+	}
+	return wrapKyberPublicKey(pub), nil
+}
+
+// SignedKeyBundle pairs a classical signed prekey with its Kyber
+// counterpart under a single identity signature computed over both public
+// keys, the shape Signal's servers expect a client to upload for the
+// PQXDH handshake rather than just the classical X3DH signed prekey.
+type SignedKeyBundle struct {
+	SignedPreKey *SignedPreKeyRecord
+	KyberPreKey  *KyberPreKeyRecord
+	Signature    []byte
+}
+
+// NewSignedKeyBundle signs the concatenation of spkr's and kpkr's public
+// keys via s and bundles the two records together under that single
+// signature. Like NewSignedPreKeyRecordWithSigner, it takes a Signer
+// rather than a raw identity PrivateKey so PQXDH bundles get the same
+// remote-signer support as classic signed prekeys.
+func NewSignedKeyBundle(ctx context.Context, s Signer, spkr *SignedPreKeyRecord, kpkr *KyberPreKeyRecord) (*SignedKeyBundle, error) {
+	spkPublicKey, err := spkr.GetPublicKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get signed prekey public key: %w", err)
+	}
+	spkPublicKeyBytes, err := spkPublicKey.Serialize()
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize signed prekey public key: %w", err)
+	}
+
+	kyberPublicKey, err := kpkr.GetPublicKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get kyber prekey public key: %w", err)
+	}
+	kyberPublicKeyBytes, err := kyberPublicKey.Serialize()
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize kyber prekey public key: %w", err)
+	}
+
+	signature, err := s.SignMessage(ctx, append(spkPublicKeyBytes, kyberPublicKeyBytes...))
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign key bundle: %w", err)
+	}
+
+	return &SignedKeyBundle{
+		SignedPreKey: spkr,
+		KyberPreKey:  kpkr,
+		Signature:    signature,
+	}, nil
+}
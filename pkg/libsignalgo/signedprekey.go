@@ -22,6 +22,8 @@ package libsignalgo
 */
 import "C"
 import (
+	"context"
+	"fmt"
 	"runtime"
 	"time"
 )
@@ -57,6 +59,52 @@ func NewSignedPreKeyRecordFromPrivateKey(id uint32, timestamp time.Time, private
 	return NewSignedPreKeyRecord(id, timestamp, pub, privateKey, signature)
 }
 
+// Signer abstracts the identity private key used to sign a prekey so that
+// it never has to live in this process: PublicKey returns the identity
+// public key and SignMessage asks whatever is holding the private key
+// (an HSM, a remote signing daemon, etc.) to sign msg on the caller's
+// behalf. This is the same separation lnd's signrpc package draws between
+// the node and its signer.
+type Signer interface {
+	PublicKey() (*PublicKey, error)
+	SignMessage(ctx context.Context, msg []byte) ([]byte, error)
+}
+
+// NewSignedPreKeyRecordWithSigner builds a signed prekey record the same
+// way NewSignedPreKeyRecord does, except the Ed25519 identity key never
+// needs to be loaded into this process: a new prekey keypair is generated
+// locally, its serialized public key is handed to s.SignMessage for
+// signing, and the signature comes back from whatever is holding the
+// identity key instead of from a local PrivateKey. The prekey's own
+// PrivateKey is returned alongside the record purely for the caller's
+// convenience; it is also embedded in the record itself, as it would be
+// for any other SignedPreKeyRecord.
+func NewSignedPreKeyRecordWithSigner(ctx context.Context, id uint32, timestamp time.Time, s Signer) (*SignedPreKeyRecord, *PrivateKey, error) {
+	prekeyPrivateKey, err := GeneratePrivateKey()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate prekey: %w", err)
+	}
+	prekeyPublicKey, err := prekeyPrivateKey.GetPublicKey()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to derive prekey public key: %w", err)
+	}
+	serializedPrekey, err := prekeyPublicKey.Serialize()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to serialize prekey public key: %w", err)
+	}
+
+	signature, err := s.SignMessage(ctx, serializedPrekey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to sign prekey: %w", err)
+	}
+
+	spkr, err := NewSignedPreKeyRecord(id, timestamp, prekeyPublicKey, prekeyPrivateKey, signature)
+	if err != nil {
+		return nil, nil, err
+	}
+	return spkr, prekeyPrivateKey, nil
+}
+
 func DeserializeSignedPreKeyRecord(serialized []byte) (*SignedPreKeyRecord, error) {
 	var spkr *C.SignalSignedPreKeyRecord
 	signalFfiError := C.signal_signed_pre_key_record_deserialize(&spkr, BytesToBuffer(serialized))
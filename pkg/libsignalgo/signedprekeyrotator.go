@@ -0,0 +1,240 @@
+// mautrix-signal - A Matrix-signal puppeting bridge.
+// Copyright (C) 2023 Sumner Evans
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package libsignalgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// RotationPolicy controls when a SignedPreKeyRotator replaces the active
+// signed prekey and how many retired records it keeps around afterwards.
+type RotationPolicy struct {
+	// MaxAge is how long a signed prekey may be in use before it is rotated.
+	MaxAge time.Duration
+	// RetainOld is the number of previously-active records, beyond the
+	// current one, to keep in the store rather than pruning.
+	RetainOld int
+	// RotateJitter randomizes the check interval slightly so that many
+	// rotators started at once don't all rotate in lockstep.
+	RotateJitter time.Duration
+}
+
+// Publisher uploads a freshly rotated signed prekey record to wherever it
+// needs to be advertised, such as the Signal server.
+type Publisher func(ctx context.Context, loc KeyLocator, spkr *SignedPreKeyRecord) error
+
+// SignedPreKeyRotator keeps a single KeyLocator family's signed prekey
+// fresh according to a RotationPolicy, replacing the ad-hoc rotation loop
+// every Signal bridge otherwise has to write by hand.
+type SignedPreKeyRotator struct {
+	Store  SignedPreKeyStore
+	Signer Signer
+	Family uint16
+	Policy RotationPolicy
+	// Publish, if set, is called with every rotated record so it can be
+	// uploaded to the Signal server.
+	Publish Publisher
+	// StorePrivateKey, if set, is called with the locally generated
+	// prekey's PrivateKey right after it is signed and before the record
+	// is put in Store. The same private key is also embedded in the
+	// record that Store receives.
+	StorePrivateKey func(loc KeyLocator, priv *PrivateKey) error
+
+	// mu guards currentIndex and initialized, since Start's background loop
+	// and a caller's direct RotateNow/Prune calls may run concurrently.
+	mu           sync.Mutex
+	currentIndex uint16
+	initialized  bool
+}
+
+// Start runs the rotation loop until ctx is canceled, periodically
+// inspecting the active record's GetTimestamp() and calling RotateNow
+// whenever it is older than Policy.MaxAge.
+func (r *SignedPreKeyRotator) Start(ctx context.Context) error {
+	ticker := time.NewTicker(r.tickInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if err := r.checkAndRotate(ctx); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// tickInterval is how often Start checks whether the active prekey needs
+// rotating: a quarter of MaxAge, perturbed by up to Policy.RotateJitter so
+// that many rotators started at the same time don't all check (and thus
+// rotate) in lockstep.
+func (r *SignedPreKeyRotator) tickInterval() time.Duration {
+	interval := r.Policy.MaxAge / 4
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	if r.Policy.RotateJitter > 0 {
+		interval += time.Duration(rand.Int63n(int64(r.Policy.RotateJitter)))
+	}
+	return interval
+}
+
+// ensureInitializedLocked recovers currentIndex from the store's existing
+// state the first time the rotator runs in a process, so a restart
+// continues the index sequence instead of re-deriving indices that were
+// already used (and silently clobbering the prekeys stored under them).
+// mu must be held by the caller.
+func (r *SignedPreKeyRotator) ensureInitializedLocked() error {
+	if r.initialized {
+		return nil
+	}
+	locs, err := r.Store.ListFamily(r.Family)
+	if err != nil {
+		return fmt.Errorf("failed to list prekeys to recover rotation state: %w", err)
+	}
+	for _, loc := range locs {
+		if loc.Index > r.currentIndex {
+			r.currentIndex = loc.Index
+		}
+	}
+	r.initialized = true
+	return nil
+}
+
+// currentLocator returns the locator of the prekey that is currently
+// active, initializing currentIndex from the store first if needed.
+func (r *SignedPreKeyRotator) currentLocator() (KeyLocator, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.ensureInitializedLocked(); err != nil {
+		return KeyLocator{}, err
+	}
+	return KeyLocator{Family: r.Family, Index: r.currentIndex}, nil
+}
+
+// nextLocator allocates and returns the locator for the next prekey in the
+// sequence, initializing currentIndex from the store first if needed.
+func (r *SignedPreKeyRotator) nextLocator() (KeyLocator, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.ensureInitializedLocked(); err != nil {
+		return KeyLocator{}, err
+	}
+	r.currentIndex++
+	return KeyLocator{Family: r.Family, Index: r.currentIndex}, nil
+}
+
+func (r *SignedPreKeyRotator) checkAndRotate(ctx context.Context) error {
+	loc, err := r.currentLocator()
+	if err != nil {
+		return err
+	}
+	current, err := r.Store.Get(loc)
+	if errors.Is(err, ErrSignedPreKeyNotFound) {
+		_, err := r.RotateNow(ctx)
+		return err
+	} else if err != nil {
+		return fmt.Errorf("failed to get current prekey: %w", err)
+	}
+	timestamp, err := current.GetTimestamp()
+	if err != nil {
+		return fmt.Errorf("failed to get current prekey timestamp: %w", err)
+	}
+	if time.Since(timestamp) < r.Policy.MaxAge {
+		return nil
+	}
+	_, err = r.RotateNow(ctx)
+	return err
+}
+
+// RotateNow immediately signs and stores a new signed prekey, publishes it
+// via Publish if set, and prunes records beyond Policy.RetainOld.
+func (r *SignedPreKeyRotator) RotateNow(ctx context.Context) (*SignedPreKeyRecord, error) {
+	loc, err := r.nextLocator()
+	if err != nil {
+		return nil, err
+	}
+
+	spkr, priv, err := NewSignedPreKeyRecordWithSigner(ctx, loc.ID(), time.Now(), r.Signer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign rotated prekey: %w", err)
+	}
+	if r.StorePrivateKey != nil {
+		if err := r.StorePrivateKey(loc, priv); err != nil {
+			return nil, fmt.Errorf("failed to store rotated prekey's private key: %w", err)
+		}
+	}
+	if err := r.Store.Put(loc, spkr); err != nil {
+		return nil, fmt.Errorf("failed to store rotated prekey: %w", err)
+	}
+	if r.Publish != nil {
+		if err := r.Publish(ctx, loc, spkr); err != nil {
+			return nil, fmt.Errorf("failed to publish rotated prekey: %w", err)
+		}
+	}
+	if err := r.Prune(ctx); err != nil {
+		return nil, err
+	}
+	return spkr, nil
+}
+
+// Prune evicts every record in the rotator's family beyond the newest
+// Policy.RetainOld+1 (the current record plus Policy.RetainOld older
+// ones): it removes each one from Store before calling Destroy,
+// so the CGo-owned memory is released immediately rather than waiting for
+// the finalizer, and the store never hands back a pointer to already-freed
+// memory on a later Get or ListFamily.
+func (r *SignedPreKeyRotator) Prune(ctx context.Context) error {
+	locs, err := r.Store.ListFamily(r.Family)
+	if err != nil {
+		return fmt.Errorf("failed to list prekeys for pruning: %w", err)
+	}
+	evict := evictionCandidates(locs, r.Policy.RetainOld)
+	for _, loc := range evict {
+		spkr, err := r.Store.Get(loc)
+		if err != nil {
+			return fmt.Errorf("failed to load prekey %d/%d for eviction: %w", loc.Family, loc.Index, err)
+		}
+		if err := r.Store.Delete(loc); err != nil {
+			return fmt.Errorf("failed to delete prekey %d/%d from store: %w", loc.Family, loc.Index, err)
+		}
+		if err := spkr.Destroy(); err != nil {
+			return fmt.Errorf("failed to destroy evicted prekey %d/%d: %w", loc.Family, loc.Index, err)
+		}
+	}
+	return nil
+}
+
+// evictionCandidates returns the locators in locs that fall outside the
+// newest retainOld+1 indices, oldest first.
+func evictionCandidates(locs []KeyLocator, retainOld int) []KeyLocator {
+	keep := retainOld + 1
+	if len(locs) <= keep {
+		return nil
+	}
+	sorted := make([]KeyLocator, len(locs))
+	copy(sorted, locs)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Index < sorted[j].Index })
+	return sorted[:len(sorted)-keep]
+}
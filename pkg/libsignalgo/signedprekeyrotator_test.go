@@ -0,0 +1,73 @@
+// mautrix-signal - A Matrix-signal puppeting bridge.
+// Copyright (C) 2023 Sumner Evans
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package libsignalgo
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEvictionCandidates(t *testing.T) {
+	unsorted := []KeyLocator{
+		{Family: 1, Index: 3},
+		{Family: 1, Index: 1},
+		{Family: 1, Index: 4},
+		{Family: 1, Index: 2},
+	}
+
+	tests := []struct {
+		name      string
+		locs      []KeyLocator
+		retainOld int
+		want      []KeyLocator
+	}{
+		{"nothing to evict when under the limit", unsorted, 3, nil},
+		{"nothing to evict when exactly at the limit", unsorted, 2, nil},
+		{"evicts the single oldest beyond the limit, oldest first", unsorted, 1, []KeyLocator{
+			{Family: 1, Index: 1},
+		}},
+		{"evicts everything but the newest", unsorted, 0, []KeyLocator{
+			{Family: 1, Index: 1},
+			{Family: 1, Index: 2},
+			{Family: 1, Index: 3},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := evictionCandidates(tt.locs, tt.retainOld)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("evictionCandidates(_, %d) = %+v, want %+v", tt.retainOld, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvictionCandidates_DoesNotMutateInput(t *testing.T) {
+	locs := []KeyLocator{
+		{Family: 1, Index: 3},
+		{Family: 1, Index: 1},
+		{Family: 1, Index: 2},
+	}
+	original := make([]KeyLocator, len(locs))
+	copy(original, locs)
+
+	evictionCandidates(locs, 0)
+
+	if !reflect.DeepEqual(locs, original) {
+		t.Errorf("evictionCandidates mutated its input: got %+v, want %+v", locs, original)
+	}
+}
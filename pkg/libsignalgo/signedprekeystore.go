@@ -0,0 +1,62 @@
+// mautrix-signal - A Matrix-signal puppeting bridge.
+// Copyright (C) 2023 Sumner Evans
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package libsignalgo
+
+import "errors"
+
+// ErrSignedPreKeyNotFound is returned by SignedPreKeyStore.Get when no
+// record is stored under the requested KeyLocator, so callers can tell a
+// missing record apart from a real store failure.
+var ErrSignedPreKeyNotFound = errors.New("signed prekey not found")
+
+// KeyLocator addresses a signed prekey by family and index rather than by
+// libsignal's opaque 32-bit id, mirroring the key-family/key-index scheme
+// lnd's signrpc package uses for its own KeyLocator. Families let a caller
+// keep identity, one-time, PQ, and linked-device prekeys in separate id
+// spaces instead of inventing its own id allocation on top of libsignal's.
+//
+// Family and Index are each 16 bits wide because ID packs them into the
+// two halves of libsignal's 32-bit id with no truncation or collisions.
+type KeyLocator struct {
+	Family uint16
+	Index  uint16
+}
+
+// ID derives the 32-bit id libsignal's record constructors expect from the
+// locator's family and index. The mapping is deterministic, so rotating a
+// prekey and re-deriving its id from the same (family, index) always
+// yields the same result, and distinct locators always yield distinct ids.
+func (loc KeyLocator) ID() uint32 {
+	return uint32(loc.Family)<<16 | uint32(loc.Index)
+}
+
+// SignedPreKeyStore manages SignedPreKeyRecords addressed by KeyLocator
+// instead of by their opaque id, so a caller can look up and enumerate
+// prekeys without tracking ids itself.
+type SignedPreKeyStore interface {
+	// Put saves spkr under loc, replacing any record already stored there.
+	Put(loc KeyLocator, spkr *SignedPreKeyRecord) error
+	// Get returns the record stored under loc, or ErrSignedPreKeyNotFound
+	// if none exists.
+	Get(loc KeyLocator) (*SignedPreKeyRecord, error)
+	// ListFamily returns the locators of every record stored under family.
+	ListFamily(family uint16) ([]KeyLocator, error)
+	// Delete removes the record stored under loc, if any. It does not
+	// destroy the record; the caller owns its CGo memory and must call
+	// Destroy itself once it is no longer needed.
+	Delete(loc KeyLocator) error
+}
@@ -0,0 +1,56 @@
+// mautrix-signal - A Matrix-signal puppeting bridge.
+// Copyright (C) 2023 Sumner Evans
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package libsignalgo
+
+import "testing"
+
+func TestKeyLocator_ID(t *testing.T) {
+	tests := []struct {
+		name string
+		loc  KeyLocator
+		want uint32
+	}{
+		{"zero", KeyLocator{Family: 0, Index: 0}, 0},
+		{"family only", KeyLocator{Family: 1, Index: 0}, 1 << 16},
+		{"index only", KeyLocator{Family: 0, Index: 1}, 1},
+		{"max family and index", KeyLocator{Family: 0xffff, Index: 0xffff}, 0xffffffff},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.loc.ID(); got != tt.want {
+				t.Errorf("KeyLocator{%d, %d}.ID() = %#x, want %#x", tt.loc.Family, tt.loc.Index, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeyLocator_ID_NoCollisions(t *testing.T) {
+	locs := []KeyLocator{
+		{Family: 0, Index: 1},
+		{Family: 1, Index: 0},
+		{Family: 1, Index: 1},
+		{Family: 2, Index: 1},
+	}
+	seen := make(map[uint32]KeyLocator, len(locs))
+	for _, loc := range locs {
+		id := loc.ID()
+		if other, ok := seen[id]; ok {
+			t.Errorf("KeyLocator %+v and %+v both derive id %#x", loc, other, id)
+		}
+		seen[id] = loc
+	}
+}